@@ -1,6 +1,7 @@
 package common
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -12,10 +13,14 @@ import (
 	addoncfg "github.com/stolostron/multicluster-observability-addon/internal/addon/config"
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/structured-merge-diff/v6/fieldpath"
+	"sigs.k8s.io/structured-merge-diff/v6/value"
 )
 
 var errUnsupportedKind = errors.New("unsupported resource kind")
@@ -60,7 +65,6 @@ func EnsureAddonConfig(ctx context.Context, logger logr.Logger, k8s client.Clien
 	}
 
 	desiredCmao := cmao.DeepCopy()
-	desiredCmao.ManagedFields = nil // required for patching with ssa
 	ensureConfigsInAddon(desiredCmao, configs)
 
 	// If there are no changes, nothing to do
@@ -68,7 +72,12 @@ func EnsureAddonConfig(ctx context.Context, logger logr.Logger, k8s client.Clien
 		return nil
 	}
 
-	if err := ServerSideApply(ctx, k8s, desiredCmao, nil); err != nil {
+	applyObj, err := newPlacementConfigsApplyObject(desiredCmao, configs)
+	if err != nil {
+		return fmt.Errorf("failed to build CMAO apply configuration: %w", err)
+	}
+
+	if err := ServerSideApply(ctx, k8s, applyObj, nil); err != nil {
 		return fmt.Errorf("failed to apply updated CMAO configuration: %w", err)
 	}
 
@@ -111,6 +120,83 @@ func ensureConfigsInAddon(cmao *addonv1alpha1.ClusterManagementAddOn, configs []
 	}
 }
 
+// newPlacementConfigsApplyObject returns a partial ClusterManagementAddOn used for server-side apply.
+// Only the placement configs are set so that the addon does not own fields managed by other actors,
+// like supportedConfigs or rollout strategies. Placements are included when they are targeted by the
+// default configs or when the addon already owns their configs, as omitting the latter would
+// remove the previously applied configs.
+func newPlacementConfigsApplyObject(cmao *addonv1alpha1.ClusterManagementAddOn, defaultConfigs []DefaultConfig) (*unstructured.Unstructured, error) {
+	ownedFields, err := addonOwnedFields(cmao.ManagedFields)
+	if err != nil {
+		return nil, err
+	}
+
+	targeted := map[addonv1alpha1.PlacementRef]struct{}{}
+	for _, cfg := range defaultConfigs {
+		targeted[cfg.PlacementRef] = struct{}{}
+	}
+
+	placements := []any{}
+	for _, placement := range cmao.Spec.InstallStrategy.Placements {
+		_, isTargeted := targeted[placement.PlacementRef]
+		if !isTargeted && !ownedFields.Has(placementConfigsPath(placement.PlacementRef)) {
+			continue
+		}
+
+		configs := make([]any, 0, len(placement.Configs))
+		for _, cfg := range placement.Configs {
+			uCfg, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&cfg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert config %s/%s: %w", cfg.Namespace, cfg.Name, err)
+			}
+			configs = append(configs, uCfg)
+		}
+
+		placements = append(placements, map[string]any{
+			"namespace": placement.Namespace,
+			"name":      placement.Name,
+			"configs":   configs,
+		})
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(addonv1alpha1.GroupVersion.WithKind("ClusterManagementAddOn"))
+	obj.SetName(cmao.Name)
+	// Keep the optimistic lock so concurrent changes to the atomic configs lists result in a conflict.
+	obj.SetResourceVersion(cmao.ResourceVersion)
+	if err := unstructured.SetNestedSlice(obj.Object, placements, "spec", "installStrategy", "placements"); err != nil {
+		return nil, fmt.Errorf("failed to set placements: %w", err)
+	}
+
+	return obj, nil
+}
+
+// addonOwnedFields returns the set of fields applied by the addon field manager.
+func addonOwnedFields(managedFields []metav1.ManagedFieldsEntry) (*fieldpath.Set, error) {
+	owned := &fieldpath.Set{}
+	for _, entry := range managedFields {
+		if entry.Manager != addoncfg.Name || entry.Operation != metav1.ManagedFieldsOperationApply || entry.FieldsV1 == nil {
+			continue
+		}
+
+		set := &fieldpath.Set{}
+		if err := set.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			return nil, fmt.Errorf("failed to parse managed fields of %s: %w", entry.Manager, err)
+		}
+		owned = owned.Union(set)
+	}
+
+	return owned, nil
+}
+
+func placementConfigsPath(ref addonv1alpha1.PlacementRef) fieldpath.Path {
+	key := value.FieldList{
+		{Name: "name", Value: value.NewValueInterface(ref.Name)},
+		{Name: "namespace", Value: value.NewValueInterface(ref.Namespace)},
+	}
+	return fieldpath.MakePathOrDie("spec", "installStrategy", "placements", &key, "configs")
+}
+
 func ObjectToAddonConfig(obj client.Object) (addonv1alpha1.AddOnConfig, error) {
 	gvk := obj.GetObjectKind().GroupVersionKind()
 
@@ -1,13 +1,27 @@
 package common
 
 import (
+	"context"
 	"testing"
 
+	"github.com/go-logr/logr"
 	cooprometheusv1alpha1 "github.com/rhobs/obo-prometheus-operator/pkg/apis/monitoring/v1alpha1"
 	addoncfg "github.com/stolostron/multicluster-observability-addon/internal/addon/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/utils/ptr"
 	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/structured-merge-diff/v6/fieldpath"
 )
 
 func TestEnsureConfigsInAddon(t *testing.T) {
@@ -209,3 +223,393 @@ func TestEnsureConfigsInAddon(t *testing.T) {
 		})
 	}
 }
+
+func TestNewPlacementConfigsApplyObject(t *testing.T) {
+	platformConfig := addonv1alpha1.AddOnConfig{
+		ConfigGroupResource: addonv1alpha1.ConfigGroupResource{
+			Group:    cooprometheusv1alpha1.SchemeGroupVersion.Group,
+			Resource: cooprometheusv1alpha1.PrometheusAgentName,
+		},
+		ConfigReferent: addonv1alpha1.ConfigReferent{
+			Name:      "platform-agent",
+			Namespace: "ns",
+		},
+	}
+	placementRefA := addonv1alpha1.PlacementRef{Namespace: "ns", Name: "a"}
+	placementRefB := addonv1alpha1.PlacementRef{Namespace: "ns", Name: "b"}
+	placementRefC := addonv1alpha1.PlacementRef{Namespace: "ns", Name: "c"}
+
+	cmao := &addonv1alpha1.ClusterManagementAddOn{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            addoncfg.Name,
+			Labels:          map[string]string{"foo": "bar"},
+			ResourceVersion: "42",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					// The addon applied the configs of placement c during a previous reconciliation
+					Manager:   addoncfg.Name,
+					Operation: metav1.ManagedFieldsOperationApply,
+					FieldsV1: &metav1.FieldsV1{
+						Raw: []byte(`{"f:spec":{"f:installStrategy":{"f:placements":{"k:{\"name\":\"c\",\"namespace\":\"ns\"}":{".":{},"f:configs":{},"f:name":{},"f:namespace":{}}}}}}`),
+					},
+				},
+				{
+					// Configs owned by another manager are not considered
+					Manager:   "other",
+					Operation: metav1.ManagedFieldsOperationApply,
+					FieldsV1: &metav1.FieldsV1{
+						Raw: []byte(`{"f:spec":{"f:installStrategy":{"f:placements":{"k:{\"name\":\"b\",\"namespace\":\"ns\"}":{".":{},"f:configs":{},"f:name":{},"f:namespace":{}}}}}}`),
+					},
+				},
+			},
+		},
+		Spec: addonv1alpha1.ClusterManagementAddOnSpec{
+			SupportedConfigs: []addonv1alpha1.ConfigMeta{
+				{ConfigGroupResource: platformConfig.ConfigGroupResource},
+			},
+			InstallStrategy: addonv1alpha1.InstallStrategy{
+				Type: addonv1alpha1.AddonInstallStrategyPlacements,
+				Placements: []addonv1alpha1.PlacementStrategy{
+					{
+						PlacementRef: placementRefA,
+						Configs:      []addonv1alpha1.AddOnConfig{platformConfig},
+						RolloutStrategy: clusterv1alpha1.RolloutStrategy{
+							Type: clusterv1alpha1.All,
+						},
+					},
+					{
+						PlacementRef: placementRefB,
+						Configs:      []addonv1alpha1.AddOnConfig{platformConfig},
+					},
+					{
+						PlacementRef: placementRefC,
+						Configs:      []addonv1alpha1.AddOnConfig{platformConfig},
+					},
+				},
+			},
+		},
+	}
+
+	obj, err := newPlacementConfigsApplyObject(cmao, []DefaultConfig{
+		{PlacementRef: placementRefA, Config: platformConfig},
+	})
+	require.NoError(t, err)
+
+	uPlatformConfig := map[string]any{
+		"group":     cooprometheusv1alpha1.SchemeGroupVersion.Group,
+		"resource":  cooprometheusv1alpha1.PrometheusAgentName,
+		"namespace": "ns",
+		"name":      "platform-agent",
+	}
+	expected := map[string]any{
+		"apiVersion": addonv1alpha1.GroupVersion.String(),
+		"kind":       "ClusterManagementAddOn",
+		"metadata": map[string]any{
+			"name":            addoncfg.Name,
+			"resourceVersion": "42",
+		},
+		"spec": map[string]any{
+			"installStrategy": map[string]any{
+				"placements": []any{
+					map[string]any{
+						"namespace": "ns",
+						"name":      "a",
+						"configs":   []any{uPlatformConfig},
+					},
+					map[string]any{
+						"namespace": "ns",
+						"name":      "c",
+						"configs":   []any{uPlatformConfig},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, obj.Object)
+}
+
+func TestEnsureAddonConfig(t *testing.T) {
+	userConfig := addonv1alpha1.AddOnConfig{
+		ConfigGroupResource: addonv1alpha1.ConfigGroupResource{
+			Group:    addonv1alpha1.GroupName,
+			Resource: addoncfg.AddonDeploymentConfigResource,
+		},
+		ConfigReferent: addonv1alpha1.ConfigReferent{
+			Name:      "user-config",
+			Namespace: "ns",
+		},
+	}
+	paConfigGR := addonv1alpha1.ConfigGroupResource{
+		Group:    cooprometheusv1alpha1.SchemeGroupVersion.Group,
+		Resource: cooprometheusv1alpha1.PrometheusAgentName,
+	}
+	platformConfig := addonv1alpha1.AddOnConfig{
+		ConfigGroupResource: paConfigGR,
+		ConfigReferent: addonv1alpha1.ConfigReferent{
+			Name:      "platform-agent",
+			Namespace: "ns",
+		},
+	}
+	uwlConfig := addonv1alpha1.AddOnConfig{
+		ConfigGroupResource: paConfigGR,
+		ConfigReferent: addonv1alpha1.ConfigReferent{
+			Name:      "user-workload-agent",
+			Namespace: "ns",
+		},
+	}
+	placementRefA := addonv1alpha1.PlacementRef{Namespace: "ns", Name: "a"}
+	placementRefB := addonv1alpha1.PlacementRef{Namespace: "ns", Name: "b"}
+	rolloutStrategy := clusterv1alpha1.RolloutStrategy{
+		Type: clusterv1alpha1.Progressive,
+		Progressive: &clusterv1alpha1.RolloutProgressive{
+			MaxConcurrency: intstr.FromInt32(2),
+		},
+	}
+	uRolloutStrategy := map[string]any{
+		"type": clusterv1alpha1.Progressive,
+		"progressive": map[string]any{
+			"maxConcurrency": int64(2),
+		},
+	}
+	uConfig := func(t *testing.T, cfg addonv1alpha1.AddOnConfig) map[string]any {
+		ret, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&cfg)
+		require.NoError(t, err)
+		return ret
+	}
+
+	// otherApply applies the CMAO with a distinct field manager, as done by the owner of the CMAO
+	otherApply := func(t *testing.T, k8s client.Client, supportedConfigs []any, placements []any) {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(addonv1alpha1.GroupVersion.WithKind("ClusterManagementAddOn"))
+		obj.SetName(addoncfg.Name)
+		spec := map[string]any{
+			"installStrategy": map[string]any{
+				"type":       addonv1alpha1.AddonInstallStrategyPlacements,
+				"placements": placements,
+			},
+		}
+		if supportedConfigs != nil {
+			spec["supportedConfigs"] = supportedConfigs
+		}
+		obj.Object["spec"] = spec
+		require.NoError(t, k8s.Patch(context.Background(), obj, client.Apply, client.ForceOwnership, client.FieldOwner("other")))
+	}
+
+	newClient := func(t *testing.T, patchCalls *int) client.Client {
+		scheme := runtime.NewScheme()
+		require.NoError(t, addonv1alpha1.AddToScheme(scheme))
+		return fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithTypeConverters(newCMAOTypeConverter(t), managedfields.NewDeducedTypeConverter()).
+			WithReturnManagedFields().
+			WithInterceptorFuncs(interceptor.Funcs{
+				Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+					*patchCalls++
+					return c.Patch(ctx, obj, patch, opts...)
+				},
+			}).
+			Build()
+	}
+
+	getCMAO := func(t *testing.T, k8s client.Client) *addonv1alpha1.ClusterManagementAddOn {
+		cmao := &addonv1alpha1.ClusterManagementAddOn{}
+		require.NoError(t, k8s.Get(context.Background(), client.ObjectKey{Name: addoncfg.Name}, cmao))
+		return cmao
+	}
+
+	supportedConfigs := []any{
+		map[string]any{"group": paConfigGR.Group, "resource": paConfigGR.Resource},
+	}
+
+	t.Run("foreign fields and placements are not owned", func(t *testing.T) {
+		patchCalls := 0
+		k8s := newClient(t, &patchCalls)
+		otherApply(t, k8s, supportedConfigs, []any{
+			map[string]any{
+				"namespace":       "ns",
+				"name":            "a",
+				"configs":         []any{uConfig(t, userConfig)},
+				"rolloutStrategy": uRolloutStrategy,
+			},
+			map[string]any{"namespace": "ns", "name": "b"},
+		})
+		patchCalls = 0
+
+		err := EnsureAddonConfig(context.Background(), logr.Discard(), k8s, []DefaultConfig{
+			{PlacementRef: placementRefA, Config: platformConfig},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, patchCalls)
+
+		cmao := getCMAO(t, k8s)
+		require.Len(t, cmao.Spec.InstallStrategy.Placements, 2)
+		assert.Equal(t, []addonv1alpha1.AddOnConfig{userConfig, platformConfig}, cmao.Spec.InstallStrategy.Placements[0].Configs)
+		assert.Equal(t, rolloutStrategy, cmao.Spec.InstallStrategy.Placements[0].RolloutStrategy)
+		assert.Len(t, cmao.Spec.SupportedConfigs, 1)
+
+		owned, err := addonOwnedFields(cmao.ManagedFields)
+		require.NoError(t, err)
+		assert.True(t, owned.Has(placementConfigsPath(placementRefA)))
+		assert.False(t, owned.Has(fieldpath.MakePathOrDie("spec", "supportedConfigs")))
+		assert.False(t, owned.Has(fieldpath.MakePathOrDie("spec", "installStrategy", "type")))
+		rolloutPath := append(placementConfigsPath(placementRefA)[:4:4], fieldpath.PathElement{FieldName: ptr.To("rolloutStrategy")})
+		assert.False(t, owned.Has(rolloutPath))
+		assert.False(t, owned.Has(placementConfigsPath(placementRefB)[:4]))
+
+		// The fields dropped by the other manager must be removed
+		otherApply(t, k8s, nil, []any{
+			map[string]any{
+				"namespace":       "ns",
+				"name":            "a",
+				"rolloutStrategy": uRolloutStrategy,
+			},
+		})
+
+		cmao = getCMAO(t, k8s)
+		assert.Empty(t, cmao.Spec.SupportedConfigs)
+		require.Len(t, cmao.Spec.InstallStrategy.Placements, 1)
+		assert.Equal(t, placementRefA, cmao.Spec.InstallStrategy.Placements[0].PlacementRef)
+		assert.Equal(t, []addonv1alpha1.AddOnConfig{userConfig, platformConfig}, cmao.Spec.InstallStrategy.Placements[0].Configs)
+	})
+
+	t.Run("resourceVersion is sent with the patch", func(t *testing.T) {
+		patchCalls := 0
+		k8s := newClient(t, &patchCalls)
+		otherApply(t, k8s, supportedConfigs, []any{
+			map[string]any{"namespace": "ns", "name": "a"},
+		})
+		initial := getCMAO(t, k8s)
+
+		sentResourceVersion := ""
+		k8s = interceptor.NewClient(k8s.(client.WithWatch), interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				sentResourceVersion = obj.GetResourceVersion()
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		})
+
+		err := EnsureAddonConfig(context.Background(), logr.Discard(), k8s, []DefaultConfig{
+			{PlacementRef: placementRefA, Config: platformConfig},
+		})
+		require.NoError(t, err)
+		// The resourceVersion guards against overwriting configs added concurrently
+		assert.Equal(t, initial.ResourceVersion, sentResourceVersion)
+	})
+
+	t.Run("no patch when default configs are present", func(t *testing.T) {
+		patchCalls := 0
+		k8s := newClient(t, &patchCalls)
+		otherApply(t, k8s, supportedConfigs, []any{
+			map[string]any{
+				"namespace": "ns",
+				"name":      "a",
+				"configs":   []any{uConfig(t, userConfig), uConfig(t, platformConfig)},
+			},
+		})
+		patchCalls = 0
+
+		err := EnsureAddonConfig(context.Background(), logr.Discard(), k8s, []DefaultConfig{
+			{PlacementRef: placementRefA, Config: platformConfig},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 0, patchCalls)
+	})
+
+	t.Run("previously applied configs are kept on untargeted placements", func(t *testing.T) {
+		patchCalls := 0
+		k8s := newClient(t, &patchCalls)
+		otherApply(t, k8s, supportedConfigs, []any{
+			map[string]any{"namespace": "ns", "name": "a"},
+			map[string]any{"namespace": "ns", "name": "b"},
+		})
+
+		err := EnsureAddonConfig(context.Background(), logr.Discard(), k8s, []DefaultConfig{
+			{PlacementRef: placementRefA, Config: platformConfig},
+			{PlacementRef: placementRefB, Config: platformConfig},
+		})
+		require.NoError(t, err)
+
+		err = EnsureAddonConfig(context.Background(), logr.Discard(), k8s, []DefaultConfig{
+			{PlacementRef: placementRefA, Config: uwlConfig},
+		})
+		require.NoError(t, err)
+
+		cmao := getCMAO(t, k8s)
+		require.Len(t, cmao.Spec.InstallStrategy.Placements, 2)
+		assert.Equal(t, []addonv1alpha1.AddOnConfig{platformConfig, uwlConfig}, cmao.Spec.InstallStrategy.Placements[0].Configs)
+		assert.Equal(t, []addonv1alpha1.AddOnConfig{platformConfig}, cmao.Spec.InstallStrategy.Placements[1].Configs)
+	})
+}
+
+// newCMAOTypeConverter returns a type converter for the ClusterManagementAddOn as the default deduced
+// converter of the fake client treats all lists as atomic. The schema mirrors the list markers of the
+// open-cluster-management.io/api addon/v1alpha1 types:
+//   - ClusterManagementAddOnSpec.SupportedConfigs: +listType=map, +listMapKey=group, +listMapKey=resource
+//   - InstallStrategy.Placements: +listType=map, +listMapKey=namespace, +listMapKey=name
+//   - PlacementStrategy.Configs: no +listType marker, hence atomic
+func newCMAOTypeConverter(t *testing.T) managedfields.TypeConverter {
+	t.Helper()
+
+	object := func(props map[string]spec.Schema) spec.Schema {
+		return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}, Properties: props}}
+	}
+	str := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"string"}}}
+	listMap := func(items spec.Schema, keys ...string) spec.Schema {
+		return spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type:  []string{"array"},
+				Items: &spec.SchemaOrArray{Schema: &items},
+			},
+			VendorExtensible: spec.VendorExtensible{Extensions: spec.Extensions{
+				"x-kubernetes-list-type":     "map",
+				"x-kubernetes-list-map-keys": keys,
+			}},
+		}
+	}
+	atomicList := func(items spec.Schema) spec.Schema {
+		return spec.Schema{SchemaProps: spec.SchemaProps{
+			Type:  []string{"array"},
+			Items: &spec.SchemaOrArray{Schema: &items},
+		}}
+	}
+
+	cmaoSchema := object(map[string]spec.Schema{
+		"apiVersion": str,
+		"kind":       str,
+		"metadata":   object(nil),
+		"spec": object(map[string]spec.Schema{
+			"supportedConfigs": listMap(object(map[string]spec.Schema{
+				"group":    str,
+				"resource": str,
+			}), "group", "resource"),
+			"installStrategy": object(map[string]spec.Schema{
+				"type": str,
+				"placements": listMap(object(map[string]spec.Schema{
+					"namespace": str,
+					"name":      str,
+					"configs": atomicList(object(map[string]spec.Schema{
+						"group":     str,
+						"resource":  str,
+						"namespace": str,
+						"name":      str,
+					})),
+					"rolloutStrategy": object(nil),
+				}), "namespace", "name"),
+			}),
+		}),
+	})
+	cmaoSchema.AddExtension("x-kubernetes-group-version-kind", []any{
+		map[string]any{
+			"group":   addonv1alpha1.GroupName,
+			"version": addonv1alpha1.GroupVersion.Version,
+			"kind":    "ClusterManagementAddOn",
+		},
+	})
+
+	tc, err := managedfields.NewTypeConverter(map[string]*spec.Schema{
+		"io.open-cluster-management.addon.v1alpha1.ClusterManagementAddOn": &cmaoSchema,
+	}, true)
+	require.NoError(t, err)
+
+	return tc
+}